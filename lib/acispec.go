@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+// ACISpec describes an ACI to be built from an arbitrary Go package, in
+// the spirit of github.com/appc/goaci: the package is `go get`'d into a
+// throwaway GOPATH, built into a static (CGO_ENABLED=0) binary, and
+// wrapped in an ImageManifest assembled from the fields below.
+type ACISpec struct {
+	// ImportPath is the Go import path to fetch and build, e.g.
+	// "github.com/coreos/etcd".
+	ImportPath string
+
+	// Name overrides the ACI name; defaults to ImportPath.
+	Name string
+	// Version is recorded as a "version" label; defaults to "latest".
+	Version string
+
+	// Exec overrides the app's exec args; defaults to running the
+	// built binary with no arguments.
+	Exec []string
+	// User and Group default to "0".
+	User  string
+	Group string
+
+	Ports       []types.Port
+	MountPoints []types.MountPoint
+
+	// Assets are extra files to copy into rootfs/, given as "src:dest"
+	// pairs, dest being relative to rootfs/.
+	Assets []string
+}
+
+// PrepareACIFromSpec builds an ACI image for spec and returns its
+// contents. It shells out to `go get` and `go build` against a
+// throwaway GOPATH, so the `go` tool must be on $PATH.
+func PrepareACIFromSpec(spec ACISpec) ([]byte, error) {
+	if spec.ImportPath == "" {
+		return nil, fmt.Errorf("ACISpec.ImportPath must not be empty")
+	}
+
+	buildDir, err := ioutil.TempDir("", "aci-build-")
+	if buildDir != "" {
+		defer os.RemoveAll(buildDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build directory: %v", err)
+	}
+
+	gopath := filepath.Join(buildDir, "gopath")
+	if err := os.MkdirAll(gopath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create throwaway GOPATH: %v", err)
+	}
+
+	binPath, err := goGetAndBuild(gopath, spec.ImportPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build %q: %v", spec.ImportPath, err)
+	}
+
+	aciDir := filepath.Join(buildDir, "ACI")
+	rootfs := filepath.Join(aciDir, "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create rootfs directory: %v", err)
+	}
+
+	binName := filepath.Base(spec.ImportPath)
+	if err := copyFile(binPath, filepath.Join(rootfs, binName), 0755); err != nil {
+		return nil, fmt.Errorf("failed to copy built binary into rootfs: %v", err)
+	}
+	for _, asset := range spec.Assets {
+		if err := copyAsset(rootfs, asset); err != nil {
+			return nil, fmt.Errorf("failed to copy asset %q: %v", asset, err)
+		}
+	}
+
+	manifest, err := buildManifest(spec, binName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build manifest: %v", err)
+	}
+	manifestData, err := manifest.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(aciDir, "manifest"), manifestData, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	fn, err := buildACI(aciDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build ACI: %v", err)
+	}
+	defer os.Remove(fn)
+	contents, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACI to memory: %v", err)
+	}
+	return contents, nil
+}
+
+func buildManifest(spec ACISpec, binName string) (*schema.ImageManifest, error) {
+	name := spec.Name
+	if name == "" {
+		name = spec.ImportPath
+	}
+	acName, err := types.NewACIdentifier(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name %q: %v", name, err)
+	}
+
+	version := spec.Version
+	if version == "" {
+		version = "latest"
+	}
+
+	execArgs := spec.Exec
+	if len(execArgs) == 0 {
+		execArgs = []string{"/" + binName}
+	}
+
+	user := spec.User
+	if user == "" {
+		user = "0"
+	}
+	group := spec.Group
+	if group == "" {
+		group = "0"
+	}
+
+	manifest := &schema.ImageManifest{
+		ACKind:    schema.ImageManifestKind,
+		ACVersion: schema.AppContainerVersion,
+		Name:      *acName,
+		Labels: types.Labels{
+			{Name: "version", Value: version},
+		},
+		App: &types.App{
+			Exec:        execArgs,
+			User:        user,
+			Group:       group,
+			Ports:       spec.Ports,
+			MountPoints: spec.MountPoints,
+		},
+	}
+	return manifest, nil
+}
+
+// goGetAndBuild fetches importPath into gopath and builds a static
+// binary for it, returning the built binary's path. It runs in
+// legacy GOPATH mode (GO111MODULE=off): the throwaway directory has
+// no go.mod of its own, and recent `go` toolchains refuse to `go get`
+// outside a module unless told to fall back to GOPATH mode.
+func goGetAndBuild(gopath, importPath string) (string, error) {
+	env := append(os.Environ(), "GOPATH="+gopath, "CGO_ENABLED=0", "GO111MODULE=off")
+
+	if err := runGo(gopath, env, "get", "-d", importPath); err != nil {
+		return "", fmt.Errorf("`go get -d` failed: %v", err)
+	}
+
+	outFile := filepath.Join(gopath, "bin", filepath.Base(importPath))
+	if err := runGo(gopath, env, "build", "-o", outFile, importPath); err != nil {
+		return "", fmt.Errorf("`go build` failed: %v", err)
+	}
+	return outFile, nil
+}
+
+func runGo(dir string, env []string, args ...string) error {
+	compiler, err := exec.LookPath("go")
+	if err != nil {
+		return fmt.Errorf("failed to find `go`: %v", err)
+	}
+	outBuf := new(bytes.Buffer)
+	errBuf := new(bytes.Buffer)
+	cmd := exec.Cmd{
+		Path:   compiler,
+		Args:   append([]string{"go"}, args...),
+		Dir:    dir,
+		Env:    env,
+		Stdout: outBuf,
+		Stderr: errBuf,
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v\nstdout:\n%v\n\nstderr:\n%v)", err, outBuf.String(), errBuf.String())
+	}
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// copyAsset copies a "src:dest" pair into rootfs, creating any
+// intermediate directories dest needs.
+func copyAsset(rootfs, spec string) error {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected src:dest, got %q", spec)
+	}
+	dest := filepath.Join(rootfs, parts[1])
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return copyFile(parts[0], dest, 0644)
+}
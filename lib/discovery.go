@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// DiscoveryOptions configures the appc meta-discovery endpoint exposed
+// at /<name>?ac-discovery=1.
+type DiscoveryOptions struct {
+	// Name is the appc name clients discover against, e.g.
+	// "example.com/testprog". Defaults to the top-level Options.Name.
+	Name string
+
+	// VersionTemplate is the ac-discovery template URL, with
+	// {name}, {version}, {os}, {arch} and {ext} placeholders for
+	// clients to expand. Defaults to a template pointing at this
+	// server's /find/ endpoint.
+	VersionTemplate string
+}
+
+// discoveryHandler implements appc meta discovery: a GET with
+// ac-discovery=1 gets back an HTML document with ac-discovery and
+// ac-discovery-pubkeys meta tags, as described at
+// https://github.com/appc/spec/blob/master/spec/discovery.md.
+func (s *Server) discoveryHandler(opts DiscoveryOptions, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ac-discovery") != "1" {
+			http.NotFound(w, r)
+			return
+		}
+
+		template := opts.VersionTemplate
+		if template == "" {
+			template = s.URL + "/find/{name}.{ext}?version={version}&os={os}&arch={arch}"
+		}
+		pubkeysURL := s.URL + "/pubkeys.gpg"
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<html>
+<head>
+<meta name="ac-discovery" content="%s %s">
+<meta name="ac-discovery-pubkeys" content="%s %s">
+</head>
+<body></body>
+</html>
+`, name, template, name, pubkeysURL)
+	}
+}
@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Scenario scripts a sequence of canned responses for specific
+// request paths, e.g. to make a client's first two GETs of an image
+// fail with 401 before a third succeeds.
+type Scenario struct {
+	Steps []ScenarioStep `json:"steps"`
+}
+
+// ScenarioStep describes one canned response. Steps are consumed in
+// order for requests matching the same Method and Path; once a
+// method+path's steps are exhausted, the server falls back to its
+// normal handler for that request.
+type ScenarioStep struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+
+	// Delay, if set, is parsed with time.ParseDuration and slept
+	// before the response is written.
+	Delay string `json:"delay"`
+}
+
+// LoadScenario reads and parses a Scenario from a JSON file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %v", err)
+	}
+	var s Scenario
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario file: %v", err)
+	}
+	return &s, nil
+}
+
+// RequestRecord is one logged request, kept in the order received.
+type RequestRecord struct {
+	Method  string
+	Path    string
+	Headers http.Header
+
+	// Matched is true if a Scenario step answered this request.
+	Matched bool
+}
+
+// scenarioRunner tracks which Scenario steps are still pending and
+// logs every request the server sees, matched or not.
+type scenarioRunner struct {
+	mu      sync.Mutex
+	pending map[string][]ScenarioStep
+	log     []RequestRecord
+}
+
+func newScenarioRunner(s *Scenario) *scenarioRunner {
+	pending := make(map[string][]ScenarioStep)
+	if s != nil {
+		for _, step := range s.Steps {
+			key := scenarioKey(step.Method, step.Path)
+			pending[key] = append(pending[key], step)
+		}
+	}
+	return &scenarioRunner{pending: pending}
+}
+
+func scenarioKey(method, path string) string {
+	return method + " " + path
+}
+
+// handle records req and, if a Scenario step is queued for its
+// method+path, answers it directly and returns true. Otherwise it
+// returns false so the caller falls through to its normal handler.
+func (r *scenarioRunner) handle(w http.ResponseWriter, req *http.Request) bool {
+	r.mu.Lock()
+	key := scenarioKey(req.Method, req.URL.Path)
+	steps := r.pending[key]
+	var step *ScenarioStep
+	if len(steps) > 0 {
+		step = &steps[0]
+		r.pending[key] = steps[1:]
+	}
+	r.log = append(r.log, RequestRecord{
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Headers: req.Header,
+		Matched: step != nil,
+	})
+	r.mu.Unlock()
+
+	if step == nil {
+		return false
+	}
+
+	if step.Delay != "" {
+		if d, err := time.ParseDuration(step.Delay); err == nil {
+			time.Sleep(d)
+		}
+	}
+	for k, v := range step.Headers {
+		w.Header().Set(k, v)
+	}
+	status := step.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(step.Body))
+	return true
+}
+
+func (r *scenarioRunner) requests() []RequestRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]RequestRecord, len(r.log))
+	copy(out, r.log)
+	return out
+}
+
+// unmatchedSteps returns any scenario steps that were never consumed.
+func (r *scenarioRunner) unmatchedSteps() []ScenarioStep {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []ScenarioStep
+	for _, steps := range r.pending {
+		out = append(out, steps...)
+	}
+	return out
+}
@@ -0,0 +1,263 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Type selects which authentication scheme the server enforces on the
+// ACI download endpoint.
+type Type int
+
+const (
+	None Type = iota
+	Basic
+	Oauth
+)
+
+const (
+	basicUser  = "admin"
+	basicPass  = "secret"
+	oauthToken = "test-token"
+
+	defaultName = "testprog"
+)
+
+// Options configures StartServer. The zero value serves the built-in
+// countdown-program ACI as "testprog" over plain HTTP, signed with a
+// freshly generated ephemeral key.
+type Options struct {
+	// TLS, when set, serves over HTTPS instead of plain HTTP.
+	TLS *TLSOptions
+
+	// Name is the ACI name the image is served under, at
+	// /find/<name>.aci. Defaults to "testprog".
+	Name string
+
+	// ACI overrides the served image; defaults to the built-in
+	// countdown program. Typically built with PrepareACIFromSpec.
+	ACI []byte
+
+	// Signing configures the key used to sign the served ACI. A zero
+	// value generates an ephemeral key on startup.
+	Signing SigningConfig
+
+	// Discovery configures the appc meta-discovery endpoint. A zero
+	// value discovers under the top-level Name.
+	Discovery DiscoveryOptions
+
+	// Scenario, if set, scripts canned responses for specific
+	// requests ahead of the server's normal handlers - see Scenario's
+	// doc comment.
+	Scenario *Scenario
+
+	// Strict makes Close return an error if any Scenario step went
+	// unmatched.
+	Strict bool
+}
+
+// Server represents a running test ACI server. URL is the base address
+// clients should fetch images from, Conf holds a human-readable summary
+// of how to authenticate (printed once at startup), and Stop/Msg let the
+// CLI shut the server down and surface log lines as they happen.
+type Server struct {
+	URL  string
+	Conf string
+
+	Stop chan struct{}
+
+	// Msg streams a "METHOD path" line per incoming request. Sends are
+	// non-blocking, so request handling never stalls on it; a caller
+	// that never reads it (e.g. a test using Requests() instead) just
+	// misses log lines once the buffer fills.
+	Msg chan string
+
+	ln       net.Listener
+	srv      *http.Server
+	scenario *scenarioRunner
+	strict   bool
+}
+
+// StartServer starts a server per opts and returns once its listener is
+// ready to accept connections. It returns an error if opts.Signing
+// names a key that can't be loaded or the ACI can't be signed.
+func StartServer(auth Type, opts Options) (*Server, error) {
+	name := opts.Name
+	if name == "" {
+		name = defaultName
+	}
+	aci := opts.ACI
+	if aci == nil {
+		aci = builtinACI()
+	}
+
+	s := &Server{
+		Stop: make(chan struct{}),
+		Msg:  make(chan string, 256),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("/find/%s.aci", name), s.authHandler(auth, s.serveBytes(aci, "application/octet-stream")))
+
+	sign, err := newSigner(opts.Signing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up signing: %v", err)
+	}
+	sig, err := sign.sign(aci)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign ACI: %v", err)
+	}
+	mux.HandleFunc(fmt.Sprintf("/find/%s.aci.asc", name), s.authHandler(auth, s.serveBytes(sig, "application/pgp-signature")))
+
+	pubKey, err := sign.publicKeyArmor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export public key: %v", err)
+	}
+	mux.HandleFunc("/pubkeys.gpg", s.serveBytes(pubKey, "application/pgp-keys"))
+
+	discName := opts.Discovery.Name
+	if discName == "" {
+		discName = name
+	}
+	mux.HandleFunc("/"+discName, s.discoveryHandler(opts.Discovery, discName))
+
+	mux.HandleFunc("/stop", s.handleStop)
+
+	s.scenario = newScenarioRunner(opts.Scenario)
+	s.strict = opts.Strict
+	s.srv = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case s.Msg <- fmt.Sprintf("%s %s", r.Method, r.URL.Path):
+		default:
+		}
+		if s.scenario.handle(w, r) {
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})}
+
+	if opts.TLS != nil {
+		s.startTLS(*opts.TLS)
+	} else {
+		s.startPlain()
+	}
+
+	s.Conf = confString(auth) + s.Conf
+	s.Conf += fmt.Sprintf("PGP public key at %s/pubkeys.gpg (fingerprint %s)\n", s.URL, sign.fingerprint())
+	s.Conf += fmt.Sprintf("Try: rkt --insecure-options=image,tls fetch %s/%s\n", s.URL, discName)
+	return s, nil
+}
+
+// builtinACI builds the default countdown-program ACI, falling back to
+// a nil body (served as a 500) if the build fails.
+func builtinACI() []byte {
+	aci, err := prepareACI()
+	if err != nil {
+		return nil
+	}
+	return aci
+}
+
+func (s *Server) startPlain() {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("failed to listen: %v", err))
+	}
+	s.ln = ln
+	s.URL = "http://" + ln.Addr().String()
+	go s.srv.Serve(ln)
+}
+
+func (s *Server) serveBytes(b []byte, contentType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if b == nil {
+			http.Error(w, "not available", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(b)
+	}
+}
+
+func (s *Server) authHandler(auth Type, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch auth {
+		case Basic:
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != basicUser || pass != basicPass {
+				w.Header().Set("WWW-Authenticate", `Basic realm="test-aci-auth-server"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		case Oauth:
+			if r.Header.Get("Authorization") != "Bearer "+oauthToken {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="test-aci-auth-server"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleStop(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	go func() {
+		s.Stop <- struct{}{}
+	}()
+}
+
+// Requests returns every request the server has received so far, in
+// the order received, noting whether a Scenario step answered it.
+func (s *Server) Requests() []RequestRecord {
+	return s.scenario.requests()
+}
+
+// Close shuts down the listener. It is called by the CLI's event loop
+// once a stop request has been received. If the server was started
+// with a strict Scenario, Close returns an error naming any step that
+// was never matched.
+//
+// Msg is deliberately left open: a request accepted just before the
+// listener closes may still be writing to it from the shared handler,
+// and the process exits right after Close returns anyway.
+func (s *Server) Close() error {
+	var err error
+	if s.strict {
+		if unmatched := s.scenario.unmatchedSteps(); len(unmatched) > 0 {
+			names := make([]string, len(unmatched))
+			for i, step := range unmatched {
+				names[i] = scenarioKey(step.Method, step.Path)
+			}
+			err = fmt.Errorf("%d scenario step(s) never matched: %s", len(unmatched), strings.Join(names, ", "))
+		}
+	}
+	if s.ln != nil {
+		if cerr := s.ln.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func confString(auth Type) string {
+	switch auth {
+	case Basic:
+		return fmt.Sprintf("Basic auth credentials: %s:%s\n", basicUser, basicPass)
+	case Oauth:
+		return fmt.Sprintf("Oauth bearer token: %s\n", oauthToken)
+	default:
+		return ""
+	}
+}
+
+// StopServer tells a running server to shut down by POSTing to its
+// /stop endpoint. If insecure is true and host uses https://, the
+// server's certificate is not verified - this is needed while testing
+// against a server started with a self-signed cert.
+func StopServer(host string, insecure bool) (*http.Response, error) {
+	client := stopClient(insecure)
+	return client.Post(host+"/stop", "", nil)
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStartServerRequestsWithoutDrainingMsg guards against the request
+// handler blocking on an undrained Msg channel: a test that only reads
+// Requests() should never have to also run a loop draining Msg.
+func TestStartServerRequestsWithoutDrainingMsg(t *testing.T) {
+	s, err := StartServer(None, Options{})
+	if err != nil {
+		t.Fatalf("StartServer: %v", err)
+	}
+	defer s.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(s.URL + "/find/testprog.aci")
+		if err != nil {
+			done <- err
+			return
+		}
+		resp.Body.Close()
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("GET /find/testprog.aci: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request did not complete; sending to Msg is blocking request handling")
+	}
+
+	reqs := s.Requests()
+	if len(reqs) != 1 || reqs[0].Method != "GET" || reqs[0].Path != "/find/testprog.aci" {
+		t.Fatalf("unexpected request log: %+v", reqs)
+	}
+}
+
+// TestCloseStrictNamesUnmatchedSteps checks that a strict Close error
+// names the scenario step(s) that were never matched, not just a count.
+func TestCloseStrictNamesUnmatchedSteps(t *testing.T) {
+	s, err := StartServer(None, Options{
+		Scenario: &Scenario{Steps: []ScenarioStep{{Method: "GET", Path: "/never-requested"}}},
+		Strict:   true,
+	})
+	if err != nil {
+		t.Fatalf("StartServer: %v", err)
+	}
+
+	err = s.Close()
+	if err == nil {
+		t.Fatal("expected an error for an unmatched scenario step")
+	}
+	if want := "GET /never-requested"; !strings.Contains(err.Error(), want) {
+		t.Fatalf("Close error %q does not name the unmatched step %q", err, want)
+	}
+}
@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// SigningConfig configures how the server signs served ACIs. If KeyFile
+// is empty, an ephemeral RSA/OpenPGP key is generated on startup.
+type SigningConfig struct {
+	// KeyFile is the path to an armored OpenPGP private key to load.
+	KeyFile string
+}
+
+// signer wraps the OpenPGP identity used to sign ACIs and export the
+// corresponding public key.
+type signer struct {
+	entity *openpgp.Entity
+}
+
+func newSigner(cfg SigningConfig) (*signer, error) {
+	if cfg.KeyFile != "" {
+		f, err := os.Open(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open signing key %q: %v", cfg.KeyFile, err)
+		}
+		defer f.Close()
+		entities, err := openpgp.ReadArmoredKeyRing(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read armored key ring: %v", err)
+		}
+		if len(entities) == 0 {
+			return nil, fmt.Errorf("no keys found in %q", cfg.KeyFile)
+		}
+		return &signer{entity: entities[0]}, nil
+	}
+
+	entity, err := openpgp.NewEntity("test-aci-auth-server", "", "test-aci-auth-server@localhost", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral PGP key: %v", err)
+	}
+	return &signer{entity: entity}, nil
+}
+
+// sign produces a detached, ASCII-armored signature over data.
+func (s *signer) sign(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, s.entity, bytes.NewReader(data), nil); err != nil {
+		return nil, fmt.Errorf("failed to create detached signature: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// publicKeyArmor exports the signer's public key, ASCII-armored.
+func (s *signer) publicKeyArmor() ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open armor encoder: %v", err)
+	}
+	if err := s.entity.Serialize(w); err != nil {
+		return nil, fmt.Errorf("failed to serialize public key: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close armor encoder: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fingerprint returns the public key's fingerprint as uppercase hex.
+func (s *signer) fingerprint() string {
+	return fmt.Sprintf("%X", s.entity.PrimaryKey.Fingerprint)
+}
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TLSOptions configures StartServer's Options.TLS field. If CertFile
+// and KeyFile are both set, that keypair is used as-is; otherwise a
+// self-signed RSA certificate is generated in memory, covering
+// 127.0.0.1 and Host.
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+
+	// Host is an extra hostname to include in a generated certificate's
+	// subject alternative names. Ignored when CertFile/KeyFile are set.
+	Host string
+}
+
+func (s *Server) startTLS(opts TLSOptions) {
+	cert, caPath, err := loadOrGenerateCert(opts)
+	if err != nil {
+		panic(fmt.Sprintf("failed to set up TLS: %v", err))
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to listen: %v", err))
+	}
+	s.ln = ln
+	s.URL = "https://" + ln.Addr().String()
+	go s.srv.Serve(ln)
+
+	if caPath != "" {
+		s.Conf = fmt.Sprintf("Self-signed CA certificate written to %s\n", caPath)
+	}
+}
+
+func loadOrGenerateCert(opts TLSOptions) (tls.Certificate, string, error) {
+	if opts.CertFile != "" && opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return tls.Certificate{}, "", fmt.Errorf("failed to load keypair: %v", err)
+		}
+		return cert, "", nil
+	}
+
+	certPEM, keyPEM, err := generateSelfSignedCert(opts.Host)
+	if err != nil {
+		return tls.Certificate{}, "", fmt.Errorf("failed to generate self-signed cert: %v", err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, "", fmt.Errorf("failed to parse generated cert: %v", err)
+	}
+
+	caFile, err := ioutil.TempFile("", "test-aci-auth-server-ca-")
+	if err != nil {
+		return tls.Certificate{}, "", fmt.Errorf("failed to create temp file for CA cert: %v", err)
+	}
+	defer caFile.Close()
+	if _, err := caFile.Write(certPEM); err != nil {
+		return tls.Certificate{}, "", fmt.Errorf("failed to write CA cert: %v", err)
+	}
+
+	return cert, caFile.Name(), nil
+}
+
+func generateSelfSignedCert(host string) (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate RSA key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"test-aci-auth-server"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if host != "" {
+		tmpl.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
+
+// stopClient returns an http.Client able to talk to a server started
+// with or without TLS. insecure disables certificate verification, for
+// use against a server running a generated self-signed cert whose CA
+// has not been imported into the caller's trust store.
+func stopClient(insecure bool) *http.Client {
+	if !insecure {
+		return http.DefaultClient
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
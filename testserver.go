@@ -1,8 +1,10 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/endocode/test-aci-auth-server/lib"
 )
@@ -29,8 +31,24 @@ func main() {
 }
 
 func start(args []string) error {
+	fs := flag.NewFlagSet("start", flag.ContinueOnError)
+	useTLS := fs.Bool("tls", false, "serve over HTTPS using a self-signed certificate")
+	tlsHost := fs.String("tls-host", "localhost", "extra hostname to cover in the generated certificate")
+	aciImportPath := fs.String("aci-import-path", "", "Go import path to `go get` and serve as the ACI, e.g. github.com/coreos/etcd")
+	aciName := fs.String("aci-name", "", "ACI name; defaults to the import path")
+	aciExec := fs.String("aci-exec", "", "comma-separated exec args for the ACI; defaults to running the built binary")
+	signingKey := fs.String("signing-key", "", "armored OpenPGP private key to sign the ACI with; defaults to an ephemeral generated key")
+	discoveryName := fs.String("discovery-name", "", "appc name to expose meta discovery under; defaults to the ACI name")
+	discoveryTemplate := fs.String("discovery-template", "", "ac-discovery template URL with {name}/{version}/{os}/{arch}/{ext} placeholders; defaults to this server's /find/ endpoint")
+	scenarioFile := fs.String("scenario", "", "path to a JSON file describing a Scenario of canned request/response steps")
+	strict := fs.Bool("strict", false, "exit with an error if any scenario step goes unmatched when the server stops")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rest := fs.Args()
+
 	typesStr := "none, basic, oauth"
-	if len(args) < 1 {
+	if len(rest) < 1 {
 		return fmt.Errorf("expected a type - %s", typesStr)
 	}
 	types := map[string]lib.Type{
@@ -38,26 +56,70 @@ func start(args []string) error {
 		"basic": lib.Basic,
 		"oauth": lib.Oauth,
 	}
-	auth, ok := types[args[0]]
+	auth, ok := types[rest[0]]
 	if !ok {
-		return fmt.Errorf("wrong type %q, should, be %s", args[0], typesStr)
+		return fmt.Errorf("wrong type %q, should, be %s", rest[0], typesStr)
+	}
+
+	name := *aciName
+	if name == "" && *aciImportPath != "" {
+		name = *aciImportPath
+	}
+
+	opts := lib.Options{
+		Name:    name,
+		Signing: lib.SigningConfig{KeyFile: *signingKey},
+		Discovery: lib.DiscoveryOptions{
+			Name:            *discoveryName,
+			VersionTemplate: *discoveryTemplate,
+		},
+	}
+	if *useTLS {
+		opts.TLS = &lib.TLSOptions{Host: *tlsHost}
+	}
+	if *aciImportPath != "" {
+		spec := lib.ACISpec{
+			ImportPath: *aciImportPath,
+			Name:       name,
+		}
+		if *aciExec != "" {
+			spec.Exec = strings.Split(*aciExec, ",")
+		}
+		built, err := lib.PrepareACIFromSpec(spec)
+		if err != nil {
+			return fmt.Errorf("failed to build ACI from %q: %v", *aciImportPath, err)
+		}
+		opts.ACI = built
+	}
+	if *scenarioFile != "" {
+		scenario, err := lib.LoadScenario(*scenarioFile)
+		if err != nil {
+			return fmt.Errorf("failed to load scenario: %v", err)
+		}
+		opts.Scenario = scenario
+	}
+	opts.Strict = *strict
+
+	server, err := lib.StartServer(auth, opts)
+	if err != nil {
+		return fmt.Errorf("failed to start server: %v", err)
 	}
-	server := lib.StartServer(auth)
 	if server.Conf != "" {
 		fmt.Printf(server.Conf)
 	}
 	fmt.Printf("Ready, waiting for connections at %s\n", server.URL)
-	loop(server)
+	if err := loop(server); err != nil {
+		return fmt.Errorf("server reported a problem while stopping: %v", err)
+	}
 	fmt.Println("Byebye")
 	return nil
 }
 
-func loop(server *lib.Server) {
+func loop(server *lib.Server) error {
 	for {
 		select {
 		case <-server.Stop:
-			server.Close()
-			return
+			return server.Close()
 		case msg, ok := <-server.Msg:
 			if ok {
 				fmt.Println(msg)
@@ -67,11 +129,20 @@ func loop(server *lib.Server) {
 }
 
 func stop(args []string) error {
-	if len(args) < 1 {
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	insecure := fs.Bool("insecure", false, "skip certificate verification when stopping an https:// server")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rest := fs.Args()
+	if len(rest) < 1 {
 		return fmt.Errorf("expected a host")
 	}
-	host := args[0]
-	res, err := lib.StopServer(host)
+	host := rest[0]
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		return fmt.Errorf("host must start with http:// or https://")
+	}
+	res, err := lib.StopServer(host, *insecure)
 	if err != nil {
 		return fmt.Errorf("failed to stop server: %v", err)
 	}